@@ -0,0 +1,111 @@
+package mls
+
+import "fmt"
+
+// RestorePolicy controls how UnmarshalGroupStateWithPolicy treats a
+// restored Group.
+type RestorePolicy struct {
+	// ForceRekey causes the restored Group to immediately rotate its
+	// leaf key via RekeyAfterRestore, limiting the window in which a
+	// compromised backup can be used to decrypt future traffic.
+	ForceRekey bool
+
+	// MaxAgeEpochs, when nonzero, refuses to restore state that is more
+	// than MaxAgeEpochs behind lastKnownEpoch.
+	MaxAgeEpochs uint64
+}
+
+// UnmarshalGroupStateWithPolicy restores a Group the same way
+// UnmarshalGroupState does, but applies policy: it can refuse state
+// that is too far behind a caller-tracked epoch high-water mark, and/or
+// force an immediate rekey so the restored group has post-compromise
+// security even if the backup it was restored from had already leaked.
+//
+// lastKnownEpoch should be a monotonic counter the caller persists
+// alongside the blob (e.g. in the same record as the snapshot); pass 0
+// if none has been recorded yet. If policy.ForceRekey is set, the
+// returned commitBytes/welcome must be broadcast to the rest of the
+// group.
+func UnmarshalGroupStateWithPolicy(data []byte, lastKnownEpoch uint64, policy RestorePolicy) (group *Group, commitBytes []byte, welcome *Welcome, err error) {
+	g, err := UnmarshalGroupState(data)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unmarshal group state with policy: %w", err)
+	}
+
+	if policy.MaxAgeEpochs > 0 && lastKnownEpoch > g.groupContext.epoch &&
+		lastKnownEpoch-g.groupContext.epoch > policy.MaxAgeEpochs {
+		return nil, nil, nil, fmt.Errorf(
+			"unmarshal group state with policy: state is %d epochs behind last known epoch %d, exceeding MaxAgeEpochs %d",
+			lastKnownEpoch-g.groupContext.epoch, lastKnownEpoch, policy.MaxAgeEpochs)
+	}
+
+	if !policy.ForceRekey {
+		return g, nil, nil, nil
+	}
+
+	commitBytes, welcome, err = g.RekeyAfterRestore()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unmarshal group state with policy: %w", err)
+	}
+
+	return g, commitBytes, welcome, nil
+}
+
+// RekeyAfterRestore generates a fresh HPKE leaf keypair for this
+// member's leaf, proposes and immediately self-commits an Update
+// advancing the epoch, and returns the commit to broadcast. Applications
+// that load group state from untrusted or long-lived storage should
+// call this right after restore so compromise of the stored blob can't
+// be used to decrypt messages sent after the restore.
+func (g *Group) RekeyAfterRestore() (commitBytes []byte, welcome *Welcome, err error) {
+	update, err := g.ProposeUpdate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("rekey after restore: propose update: %w", err)
+	}
+
+	welcome, commitBytes, err = g.CreateCommit([]Proposal{update})
+	if err != nil {
+		return nil, nil, fmt.Errorf("rekey after restore: commit: %w", err)
+	}
+
+	if _, err := g.UnmarshalAndProcessMessage(commitBytes); err != nil {
+		return nil, nil, fmt.Errorf("rekey after restore: process own commit: %w", err)
+	}
+
+	return commitBytes, welcome, nil
+}
+
+// RekeyAfterRestoreWithKeyStore behaves like RekeyAfterRestore, and
+// additionally purges the retired leaf key from ks via Delete once
+// checkpoint reports the rekeyed state has been durably persisted.
+// Handles are derived purely from (groupID, leaf index), so without
+// eventually deleting it the pre-rekey HPKE private key — the exact key
+// material a restore-driven rekey exists to retire — would keep sitting
+// in ks under that handle until some later, unrelated
+// Marshal(WithKeyStore(ks)) call happened to overwrite it.
+//
+// checkpoint is called with g after the rekey has been applied in
+// memory but before the stale handle is deleted; it should make the
+// rekeyed state durable, e.g. by calling Marshal(WithKeyStore(ks)) and
+// writing the result to disk, or GroupStore.Checkpoint. Delete only
+// runs if checkpoint returns nil, so a crash or error during persist
+// leaves the stale handle in place: the pre-rekey snapshot is still
+// recoverable, just stale, instead of pointing at a deleted key.
+func (g *Group) RekeyAfterRestoreWithKeyStore(ks KeyStore, checkpoint func(*Group) error) (commitBytes []byte, welcome *Welcome, err error) {
+	staleLeafHandle := privTreeKeyHandle(g.groupContext.groupID, int(g.myLeafIndex))
+
+	commitBytes, welcome, err = g.RekeyAfterRestore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkpoint(g); err != nil {
+		return nil, nil, fmt.Errorf("rekey after restore: checkpoint rekeyed state: %w", err)
+	}
+
+	if err := ks.Delete(staleLeafHandle); err != nil {
+		return nil, nil, fmt.Errorf("rekey after restore: purge stale leaf key: %w", err)
+	}
+
+	return commitBytes, welcome, nil
+}
@@ -0,0 +1,207 @@
+package mls
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGroupState_UnmarshalWithPolicyRejectsStaleState(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("policy-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := RestorePolicy{MaxAgeEpochs: 1}
+
+	if _, _, _, err := UnmarshalGroupStateWithPolicy(data, group.groupContext.epoch+5, policy); err == nil {
+		t.Fatal("expected error for state older than MaxAgeEpochs")
+	}
+
+	if _, _, _, err := UnmarshalGroupStateWithPolicy(data, group.groupContext.epoch, policy); err != nil {
+		t.Fatalf("unexpected error for fresh state: %v", err)
+	}
+}
+
+func TestGroupState_RekeyAfterRestore(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("rekey-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startEpoch := restored.groupContext.epoch
+
+	commitBytes, _, err := restored.RekeyAfterRestore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commitBytes) == 0 {
+		t.Fatal("expected non-empty commit bytes")
+	}
+	if restored.groupContext.epoch == startEpoch {
+		t.Fatal("expected epoch to advance after RekeyAfterRestore")
+	}
+}
+
+func TestGroupState_UnmarshalWithPolicyForceRekey(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("force-rekey-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startEpoch := group.groupContext.epoch
+
+	restored, commitBytes, welcome, err := UnmarshalGroupStateWithPolicy(data, startEpoch, RestorePolicy{ForceRekey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if welcome != nil {
+		t.Fatal("expected no welcome for a self-commit update")
+	}
+	if len(commitBytes) == 0 {
+		t.Fatal("expected non-empty commit bytes")
+	}
+	if restored.groupContext.epoch == startEpoch {
+		t.Fatal("expected epoch to advance after a forced rekey")
+	}
+}
+
+func TestGroupState_RekeyAfterRestoreWithKeyStorePurgesStaleHandle(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("rekey-keystore-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := NewMemoryKeyStore()
+
+	data, err := group.Marshal(WithKeyStore(ks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupStateWithKeyStore(data, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleHandle := privTreeKeyHandle(restored.groupContext.groupID, int(restored.myLeafIndex))
+	if _, err := ks.Load(staleHandle); err != nil {
+		t.Fatalf("expected stale handle to be present before rekey: %v", err)
+	}
+
+	checkpointed := false
+	checkpoint := func(g *Group) error {
+		checkpointed = true
+		_, err := g.Marshal(WithKeyStore(ks))
+		return err
+	}
+
+	if _, _, err := restored.RekeyAfterRestoreWithKeyStore(ks, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	if !checkpointed {
+		t.Fatal("expected checkpoint to be called")
+	}
+
+	if _, err := ks.Load(staleHandle); err == nil {
+		t.Fatal("expected stale leaf key handle to be purged after rekey")
+	}
+}
+
+// TestGroupState_RekeyAfterRestoreWithKeyStoreKeepsStaleHandleOnCheckpointFailure
+// guards against the crash-window data-loss bug this API exists to
+// avoid: if checkpoint fails (simulating a crash before the rekeyed
+// state is durably persisted), the stale leaf key handle must survive
+// so the last durable (pre-rekey) snapshot is still recoverable.
+func TestGroupState_RekeyAfterRestoreWithKeyStoreKeepsStaleHandleOnCheckpointFailure(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("rekey-keystore-checkpoint-fail-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := NewMemoryKeyStore()
+
+	data, err := group.Marshal(WithKeyStore(ks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupStateWithKeyStore(data, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleHandle := privTreeKeyHandle(restored.groupContext.groupID, int(restored.myLeafIndex))
+
+	checkpointErr := fmt.Errorf("simulated crash before persist")
+	checkpoint := func(g *Group) error {
+		return checkpointErr
+	}
+
+	if _, _, err := restored.RekeyAfterRestoreWithKeyStore(ks, checkpoint); err == nil {
+		t.Fatal("expected error when checkpoint fails")
+	}
+
+	if _, err := ks.Load(staleHandle); err != nil {
+		t.Fatalf("expected stale leaf key handle to survive a failed checkpoint: %v", err)
+	}
+}
@@ -34,21 +34,120 @@ func (pp *pendingProposal) unmarshal(s *cryptobyte.String) error {
 	return nil
 }
 
+// GroupStateVersion1 is the pre-KeyStore field layout behind a 2-byte
+// version prefix of 1: group context, ratchet tree, interim transcript
+// hash, the three key-schedule secrets, leaf index, priv tree, signature
+// key, and pending proposals, with privTree/signaturePriv always carrying
+// raw key material — no handle-mode bit and no extension area. Blobs
+// written before the version prefix existed carry no such prefix;
+// unmarshal reads the first two bytes of every blob as the version.
+// Migrate can upgrade a V1 blob, and unmarshal never treats its
+// privTree/signaturePriv entries as handles.
+const GroupStateVersion1 uint16 = 1
+
+// GroupStateVersion2 adds the KeyStore handle-mode bit (so
+// privTree/signaturePriv can carry opaque handles instead of raw key
+// material) and a TLV extension area after pendingProposals so future
+// fields (PSK IDs, an external-senders list, or the RFC 9420
+// ratchet_tree/external_pub/required_capabilities extensions) can be
+// added without another breaking change to the format.
+const GroupStateVersion2 uint16 = 2
+
+// groupStateCurrentVersion is the version Marshal writes.
+const groupStateCurrentVersion = GroupStateVersion2
+
 // groupState is an intermediate type used to marshal/unmarshal a Group.
 type groupState struct {
-	groupContext           groupContext
-	tree                   ratchetTree
-	interimTranscriptHash  []byte
-	pskSecret              []byte
-	epochSecret            []byte
-	initSecret             []byte
-	myLeafIndex            leafIndex
-	privTree               []hpkePrivateKey
-	signaturePriv          signaturePrivateKey
-	pendingProposals       []pendingProposal
+	groupContext          groupContext
+	tree                  ratchetTree
+	interimTranscriptHash []byte
+	pskSecret             []byte
+	epochSecret           []byte
+	initSecret            []byte
+	myLeafIndex           leafIndex
+	privTree              []hpkePrivateKey
+	signaturePriv         signaturePrivateKey
+	pendingProposals      []pendingProposal
+	extensions            []GroupStateExtension
+
+	// keyStore is not itself serialized. When set, marshal writes
+	// KeyStore handles in place of the raw privTree/signaturePriv bytes,
+	// and unmarshal resolves handles back to raw key material so the
+	// rest of Group never has to know the difference.
+	keyStore KeyStore
+}
+
+// GroupStateExtension is one entry in a TLV extension area: groupState's
+// own extension area, or the extensions a Group publishes via
+// MarshalGroupInfo. groupState.unmarshal fails on a critical extension
+// whose type it doesn't recognize and keeps non-critical ones, which
+// Migrate writes back out unchanged; UnmarshalGroupState does not carry
+// them onto the returned Group. UnmarshalGroupInfo accepts extensions of
+// any type, critical or not.
+type GroupStateExtension struct {
+	ExtType  uint16
+	Critical bool
+	Data     []byte
+}
+
+// NewGroupStateExtension constructs an extension entry, for passing to
+// Group.MarshalGroupInfo.
+func NewGroupStateExtension(extType uint16, critical bool, data []byte) GroupStateExtension {
+	return GroupStateExtension{ExtType: extType, Critical: critical, Data: data}
+}
+
+func (e *GroupStateExtension) marshal(b *cryptobyte.Builder) {
+	b.AddUint16(e.ExtType)
+	writeOptional(b, e.Critical)
+	writeOpaqueVec(b, e.Data)
+}
+
+func (e *GroupStateExtension) unmarshal(s *cryptobyte.String) error {
+	*e = GroupStateExtension{}
+	if !s.ReadUint16(&e.ExtType) {
+		return io.ErrUnexpectedEOF
+	}
+	if !readOptional(s, &e.Critical) {
+		return io.ErrUnexpectedEOF
+	}
+	if !readOpaqueVec(s, &e.Data) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// Reserved extension points. None of these are populated by this
+// package yet; they exist so PSK IDs, an external-senders list, or the
+// RFC 9420 ratchet_tree/external_pub/required_capabilities extensions
+// can be added later without bumping groupStateCurrentVersion again.
+const (
+	groupStateExtPSKIDs               uint16 = 1
+	groupStateExtExternalSenders      uint16 = 2
+	groupStateExtRatchetTreeExtension uint16 = 3
+	groupStateExtRequiredCapabilities uint16 = 4
+	groupStateExtExternalPub          uint16 = 5
+)
+
+func knownGroupStateExtension(t uint16) bool {
+	switch t {
+	case groupStateExtPSKIDs, groupStateExtExternalSenders, groupStateExtRatchetTreeExtension,
+		groupStateExtRequiredCapabilities, groupStateExtExternalPub:
+		return true
+	default:
+		return false
+	}
+}
+
+// keyStoreAlreadyStored reports whether ks already holds a key under
+// handle, for KeyStore implementations (like PKCS11KeyStore) whose keys
+// are provisioned out of band rather than through Store.
+func keyStoreAlreadyStored(ks KeyStore, handle []byte) bool {
+	as, ok := ks.(KeyStoreAlreadyStored)
+	return ok && as.AlreadyStored(handle)
 }
 
 func (gs *groupState) marshal(b *cryptobyte.Builder) {
+	b.AddUint16(groupStateCurrentVersion)
 	gs.groupContext.marshal(b)
 	gs.tree.marshal(b)
 	writeOpaqueVec(b, gs.interimTranscriptHash)
@@ -57,25 +156,74 @@ func (gs *groupState) marshal(b *cryptobyte.Builder) {
 	writeOpaqueVec(b, gs.initSecret)
 	b.AddUint32(uint32(gs.myLeafIndex))
 
-	// privTree: vector of optional opaqueVec entries
+	writeOptional(b, gs.keyStore != nil)
+
+	// privTree: vector of optional opaqueVec entries, or KeyStore handles
+	// when a KeyStore is configured.
 	writeVector(b, len(gs.privTree), func(b *cryptobyte.Builder, i int) {
 		key := gs.privTree[i]
 		writeOptional(b, key != nil)
-		if key != nil {
+		if key == nil {
+			return
+		}
+		if gs.keyStore == nil {
 			writeOpaqueVec(b, []byte(key))
+			return
+		}
+		handle := privTreeKeyHandle(gs.groupContext.groupID, i)
+		if !keyStoreAlreadyStored(gs.keyStore, handle) {
+			if err := gs.keyStore.Store(handle, []byte(key)); err != nil {
+				b.SetError(fmt.Errorf("store priv tree key: %w", err))
+				return
+			}
 		}
+		writeOpaqueVec(b, handle)
 	})
 
-	writeOpaqueVec(b, []byte(gs.signaturePriv))
+	if gs.keyStore == nil {
+		writeOpaqueVec(b, []byte(gs.signaturePriv))
+	} else {
+		handle := signaturePrivKeyHandle(gs.groupContext.groupID)
+		stored := true
+		if !keyStoreAlreadyStored(gs.keyStore, handle) {
+			if err := gs.keyStore.Store(handle, []byte(gs.signaturePriv)); err != nil {
+				b.SetError(fmt.Errorf("store signature key: %w", err))
+				stored = false
+			}
+		}
+		if stored {
+			writeOpaqueVec(b, handle)
+		}
+	}
 
 	// pendingProposals
 	writeVector(b, len(gs.pendingProposals), func(b *cryptobyte.Builder, i int) {
 		gs.pendingProposals[i].marshal(b)
 	})
+
+	// extensions (V2 only; a V1 blob ends after pendingProposals, and
+	// unmarshal stops reading there for V1)
+	writeVector(b, len(gs.extensions), func(b *cryptobyte.Builder, i int) {
+		gs.extensions[i].marshal(b)
+	})
 }
 
 func (gs *groupState) unmarshal(s *cryptobyte.String) error {
-	*gs = groupState{}
+	keyStore := gs.keyStore
+	*gs = groupState{keyStore: keyStore}
+
+	var version uint16
+	if !s.ReadUint16(&version) {
+		return io.ErrUnexpectedEOF
+	}
+
+	switch version {
+	case GroupStateVersion1, GroupStateVersion2:
+		// both versions share the same body; only v2 has a trailing
+		// extension area, read below.
+	default:
+		return fmt.Errorf("unmarshal group state: unsupported version %d", version)
+	}
 
 	if err := gs.groupContext.unmarshal(s); err != nil {
 		return fmt.Errorf("unmarshal group context: %w", err)
@@ -102,21 +250,46 @@ func (gs *groupState) unmarshal(s *cryptobyte.String) error {
 		return io.ErrUnexpectedEOF
 	}
 
+	// handleMode was introduced alongside the extension area in V2; V1
+	// blobs predate KeyStore entirely; their privTree/signaturePriv are
+	// always raw key material.
+	var handleMode bool
+	if version == GroupStateVersion2 {
+		if !readOptional(s, &handleMode) {
+			return io.ErrUnexpectedEOF
+		}
+	}
+
 	// privTree
+	leafIdx := 0
 	err := readVector(s, func(s *cryptobyte.String) error {
+		i := leafIdx
+		leafIdx++
+
 		var present bool
 		if !readOptional(s, &present) {
 			return io.ErrUnexpectedEOF
 		}
-		if present {
-			var key []byte
-			if !readOpaqueVec(s, &key) {
-				return io.ErrUnexpectedEOF
-			}
-			gs.privTree = append(gs.privTree, hpkePrivateKey(key))
-		} else {
+		if !present {
 			gs.privTree = append(gs.privTree, nil)
+			return nil
 		}
+
+		var raw []byte
+		if !readOpaqueVec(s, &raw) {
+			return io.ErrUnexpectedEOF
+		}
+		if handleMode {
+			if gs.keyStore == nil {
+				return fmt.Errorf("priv tree key is a key store handle but no KeyStore is configured")
+			}
+			key, err := gs.keyStore.Load(raw)
+			if err != nil {
+				return fmt.Errorf("load priv tree key %d from key store: %w", i, err)
+			}
+			raw = key
+		}
+		gs.privTree = append(gs.privTree, hpkePrivateKey(raw))
 		return nil
 	})
 	if err != nil {
@@ -127,6 +300,16 @@ func (gs *groupState) unmarshal(s *cryptobyte.String) error {
 	if !readOpaqueVec(s, &sigPriv) {
 		return io.ErrUnexpectedEOF
 	}
+	if handleMode {
+		if gs.keyStore == nil {
+			return fmt.Errorf("unmarshal signature key: blob uses key store handles but no KeyStore is configured")
+		}
+		key, err := gs.keyStore.Load(sigPriv)
+		if err != nil {
+			return fmt.Errorf("load signature key from key store: %w", err)
+		}
+		sigPriv = key
+	}
 	gs.signaturePriv = signaturePrivateKey(sigPriv)
 
 	// pendingProposals
@@ -142,11 +325,56 @@ func (gs *groupState) unmarshal(s *cryptobyte.String) error {
 		return fmt.Errorf("unmarshal pending proposals: %w", err)
 	}
 
+	if version == GroupStateVersion1 {
+		return nil
+	}
+
+	// extensions
+	err = readVector(s, func(s *cryptobyte.String) error {
+		var ext GroupStateExtension
+		if err := ext.unmarshal(s); err != nil {
+			return fmt.Errorf("unmarshal extension: %w", err)
+		}
+		if ext.Critical && !knownGroupStateExtension(ext.ExtType) {
+			return fmt.Errorf("unmarshal extension: unknown critical extension %d", ext.ExtType)
+		}
+		gs.extensions = append(gs.extensions, ext)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unmarshal extensions: %w", err)
+	}
+
 	return nil
 }
 
-// Marshal serializes the Group state for persistence.
-func (g *Group) Marshal() ([]byte, error) {
+// MarshalOption configures Group.Marshal.
+type MarshalOption func(*marshalOptions)
+
+type marshalOptions struct {
+	keyStore KeyStore
+}
+
+// WithKeyStore configures Marshal to write opaque KeyStore handles in
+// place of the raw privTree and signaturePriv key material. Keys are
+// stored under handles derived from the group ID (and, for privTree
+// entries, their index) on every Marshal call, except where ks
+// implements KeyStoreAlreadyStored and reports the handle as present;
+// pass the same KeyStore to UnmarshalGroupStateWithKeyStore to resolve
+// them back.
+func WithKeyStore(ks KeyStore) MarshalOption {
+	return func(o *marshalOptions) { o.keyStore = ks }
+}
+
+// Marshal serializes the Group state for persistence. By default all
+// key material is written in the clear; pass WithKeyStore to write
+// opaque handles instead.
+func (g *Group) Marshal(opts ...MarshalOption) ([]byte, error) {
+	var o marshalOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	gs := groupState{
 		groupContext:          g.groupContext,
 		tree:                  g.tree,
@@ -158,13 +386,30 @@ func (g *Group) Marshal() ([]byte, error) {
 		privTree:              g.privTree,
 		signaturePriv:         g.signaturePriv,
 		pendingProposals:      g.pendingProposals,
+		keyStore:              o.keyStore,
 	}
 	return marshal(&gs)
 }
 
-// UnmarshalGroupState restores a Group from bytes produced by Marshal.
+// UnmarshalGroupState restores a Group from bytes produced by Marshal
+// with no KeyStore. For blobs written with WithKeyStore, use
+// UnmarshalGroupStateWithKeyStore instead.
 func UnmarshalGroupState(data []byte) (*Group, error) {
-	var gs groupState
+	return unmarshalGroupState(data, nil)
+}
+
+// UnmarshalGroupStateWithKeyStore restores a Group from a blob written
+// with WithKeyStore(ks), resolving handles back to raw key material via
+// ks.Load. This requires ks to actually be able to return key material:
+// adapters like PKCS11KeyStore whose Load is permanently unsupported
+// can marshal a Group but can never unmarshal one back through this
+// function; see the PKCS11KeyStore doc comment.
+func UnmarshalGroupStateWithKeyStore(data []byte, ks KeyStore) (*Group, error) {
+	return unmarshalGroupState(data, ks)
+}
+
+func unmarshalGroupState(data []byte, ks KeyStore) (*Group, error) {
+	gs := groupState{keyStore: ks}
 	if err := unmarshal(data, &gs); err != nil {
 		return nil, fmt.Errorf("unmarshal group state: %w", err)
 	}
@@ -182,3 +427,49 @@ func UnmarshalGroupState(data []byte) (*Group, error) {
 		pendingProposals:      gs.pendingProposals,
 	}, nil
 }
+
+// MigrateToKeyStore rewrites a blob produced without a KeyStore so that
+// its private key material moves into ks and is replaced with handles.
+func MigrateToKeyStore(data []byte, ks KeyStore) ([]byte, error) {
+	g, err := UnmarshalGroupState(data)
+	if err != nil {
+		return nil, fmt.Errorf("migrate to key store: %w", err)
+	}
+	return g.Marshal(WithKeyStore(ks))
+}
+
+// MigrateOption configures Migrate.
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	keyStore KeyStore
+}
+
+// WithMigrateKeyStore configures Migrate to resolve KeyStore handles in
+// a blob written via Marshal(WithKeyStore(ks)), and re-marshals the
+// result with the same ks so it stays in handle mode rather than having
+// its key material spilled into the clear. Without this, Migrate fails
+// on such a blob the same way UnmarshalGroupStateWithKeyStore would if
+// called with a nil KeyStore.
+func WithMigrateKeyStore(ks KeyStore) MigrateOption {
+	return func(o *migrateOptions) { o.keyStore = ks }
+}
+
+// Migrate upgrades a groupState blob of any supported version to
+// groupStateCurrentVersion, preserving every field and leaving the
+// extension area empty if the input didn't have one. It's a thin
+// wrapper over unmarshal/marshal, which already dispatch on version.
+// Pass WithMigrateKeyStore(ks) if oldData was written with
+// Marshal(WithKeyStore(ks)); otherwise unmarshal fails on its handles.
+func Migrate(oldData []byte, opts ...MigrateOption) ([]byte, error) {
+	var o migrateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	gs := groupState{keyStore: o.keyStore}
+	if err := unmarshal(oldData, &gs); err != nil {
+		return nil, fmt.Errorf("migrate group state: %w", err)
+	}
+	return marshal(&gs)
+}
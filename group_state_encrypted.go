@@ -0,0 +1,192 @@
+package mls
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// groupStateEncryptedMagic identifies a blob produced by MarshalEncrypted.
+var groupStateEncryptedMagic = [5]byte{'M', 'L', 'S', 'G', 'S'}
+
+// groupStateEncryptedVersion1 is the only encrypted-blob format so far:
+// Argon2id KDF params, salt, nonce, and a ChaCha20-Poly1305-sealed
+// groupState payload.
+const groupStateEncryptedVersion1 uint8 = 1
+
+// Argon2Params configures the Argon2id key derivation used by
+// MarshalEncrypted and UnmarshalGroupStateEncrypted. They are stored
+// alongside the ciphertext so a blob can always be decrypted with only
+// the password that created it.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	SaltLen uint32
+}
+
+// DefaultArgon2Params are conservative interactive-use parameters.
+var DefaultArgon2Params = Argon2Params{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 1,
+	SaltLen: 16,
+}
+
+// zero overwrites b in place. It is used to scrub derived keys and
+// intermediate plaintext buffers that must not linger in memory.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// encryptedHeaderAAD builds the AEAD associated data for MarshalEncrypted:
+// the magic, version, Argon2 params and salt that precede the nonce and
+// ciphertext in the blob. The nonce is not part of the AAD. Authenticating
+// these means a stored
+// or backed-up blob can't have its KDF params or salt altered — e.g.
+// downgrading Time/Memory to weaken the KDF — without the AEAD tag
+// failing to verify.
+func encryptedHeaderAAD(version uint8, params Argon2Params, salt []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddBytes(groupStateEncryptedMagic[:])
+	b.AddUint8(version)
+	b.AddUint32(params.Time)
+	b.AddUint32(params.Memory)
+	b.AddUint8(params.Threads)
+	writeOpaqueVec(&b, salt)
+	return b.BytesOrPanic()
+}
+
+// MarshalEncryptedOption configures Group.MarshalEncrypted.
+type MarshalEncryptedOption func(*marshalEncryptedOptions)
+
+type marshalEncryptedOptions struct {
+	params Argon2Params
+}
+
+// WithArgon2Params overrides DefaultArgon2Params for this call, so
+// callers who need stronger (or, for constrained devices, cheaper) KDF
+// parameters than the default can actually reach them.
+func WithArgon2Params(params Argon2Params) MarshalEncryptedOption {
+	return func(o *marshalEncryptedOptions) { o.params = params }
+}
+
+// MarshalEncrypted serializes the Group the same way Marshal does, then
+// encrypts the result with a key derived from password via Argon2id.
+// The returned blob is self-describing: a magic header, the KDF params,
+// a random salt, and a random nonce, followed by the sealed group
+// state. This is the only Marshal variant safe to write to disk as-is,
+// since the plain Marshal output contains epochSecret, initSecret,
+// pskSecret, and signaturePriv in the clear. Pass WithArgon2Params to
+// use KDF parameters other than DefaultArgon2Params.
+func (g *Group) MarshalEncrypted(password []byte, opts ...MarshalEncryptedOption) ([]byte, error) {
+	o := marshalEncryptedOptions{params: DefaultArgon2Params}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return g.marshalEncrypted(password, o.params)
+}
+
+func (g *Group) marshalEncrypted(password []byte, params Argon2Params) ([]byte, error) {
+	plaintext, err := g.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal encrypted group state: %w", err)
+	}
+	defer zero(plaintext)
+
+	salt := make([]byte, params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("marshal encrypted group state: generate salt: %w", err)
+	}
+
+	key := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, chacha20poly1305.KeySize)
+	defer zero(key)
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal encrypted group state: init aead: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("marshal encrypted group state: generate nonce: %w", err)
+	}
+
+	aad := encryptedHeaderAAD(groupStateEncryptedVersion1, params, salt)
+	ciphertext := aead.Seal(nil, nonce, plaintext, aad)
+
+	var b cryptobyte.Builder
+	b.AddBytes(groupStateEncryptedMagic[:])
+	b.AddUint8(groupStateEncryptedVersion1)
+	b.AddUint32(params.Time)
+	b.AddUint32(params.Memory)
+	b.AddUint8(params.Threads)
+	writeOpaqueVec(&b, salt)
+	writeOpaqueVec(&b, nonce)
+	writeOpaqueVec(&b, ciphertext)
+
+	return b.BytesOrPanic(), nil
+}
+
+// UnmarshalGroupStateEncrypted decrypts a blob produced by
+// Group.MarshalEncrypted and restores the Group it contains. It rejects
+// blobs with an unrecognized magic header or version, and fails closed
+// if the AEAD tag does not verify (wrong password or corrupt data). The
+// intermediate plaintext is zeroed before returning.
+func UnmarshalGroupStateEncrypted(data, password []byte) (*Group, error) {
+	s := cryptobyte.String(data)
+
+	var magic []byte
+	if !s.ReadBytes(&magic, len(groupStateEncryptedMagic)) || subtle.ConstantTimeCompare(magic, groupStateEncryptedMagic[:]) != 1 {
+		return nil, fmt.Errorf("unmarshal encrypted group state: bad magic")
+	}
+
+	var version uint8
+	if !s.ReadUint8(&version) {
+		return nil, fmt.Errorf("unmarshal encrypted group state: truncated header")
+	}
+	if version != groupStateEncryptedVersion1 {
+		return nil, fmt.Errorf("unmarshal encrypted group state: unsupported version %d", version)
+	}
+
+	var params Argon2Params
+	if !s.ReadUint32(&params.Time) || !s.ReadUint32(&params.Memory) || !s.ReadUint8(&params.Threads) {
+		return nil, fmt.Errorf("unmarshal encrypted group state: truncated kdf params")
+	}
+
+	var salt, nonce, ciphertext []byte
+	if !readOpaqueVec(&s, &salt) || !readOpaqueVec(&s, &nonce) || !readOpaqueVec(&s, &ciphertext) {
+		return nil, fmt.Errorf("unmarshal encrypted group state: truncated body")
+	}
+
+	key := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, chacha20poly1305.KeySize)
+	defer zero(key)
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal encrypted group state: init aead: %w", err)
+	}
+
+	aad := encryptedHeaderAAD(version, params, salt)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal encrypted group state: decrypt: wrong password or corrupt data")
+	}
+	defer zero(plaintext)
+
+	// UnmarshalGroupState wires its fields directly onto the returned
+	// Group as sub-slices of whatever buffer it's given (cryptobyte
+	// reads don't copy), so unmarshaling plaintext itself would leave
+	// the Group's privTree, signaturePriv, epochSecret, initSecret, and
+	// pskSecret aliasing the same backing array the defer above zeroes
+	// on return. Unmarshal a copy instead, so the deferred zero only
+	// scrubs the decrypted buffer we no longer need, not the live Group.
+	plaintextCopy := append([]byte(nil), plaintext...)
+	return UnmarshalGroupState(plaintextCopy)
+}
@@ -0,0 +1,203 @@
+package mls
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestGroupState_EncryptedRoundtrip(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("encrypted-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("correct horse battery staple")
+
+	data, err := group.MarshalEncrypted(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupStateEncrypted(data, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello world")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+// TestGroupState_EncryptedRoundtripSurvivesZeroing guards against
+// UnmarshalGroupStateEncrypted handing back a Group whose secret fields
+// alias the decrypted plaintext buffer it zeroes before returning. A
+// single CreateApplicationMessage call can succeed even when that's
+// happened (it doesn't touch every zeroed field), so this checks the
+// fields directly and exercises the group more than once.
+func TestGroupState_EncryptedRoundtripSurvivesZeroing(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("encrypted-group-zeroing"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("correct horse battery staple")
+
+	data, err := group.MarshalEncrypted(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupStateEncrypted(data, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.epochSecret) == 0 || isAllZero(restored.epochSecret) {
+		t.Fatal("epochSecret is empty or zeroed after UnmarshalGroupStateEncrypted")
+	}
+	if len(restored.initSecret) == 0 || isAllZero(restored.initSecret) {
+		t.Fatal("initSecret is empty or zeroed after UnmarshalGroupStateEncrypted")
+	}
+	if len(restored.signaturePriv) == 0 || isAllZero([]byte(restored.signaturePriv)) {
+		t.Fatal("signaturePriv is empty or zeroed after UnmarshalGroupStateEncrypted")
+	}
+	for i, key := range restored.privTree {
+		if key != nil && isAllZero([]byte(key)) {
+			t.Fatalf("privTree[%d] is zeroed after UnmarshalGroupStateEncrypted", i)
+		}
+	}
+
+	// Exercise the restored group more than once: a corrupted secret can
+	// still produce a valid-looking single message by accident.
+	for i := 0; i < 3; i++ {
+		plaintext := []byte(fmt.Sprintf("message %d", i))
+		ciphertext, err := restored.CreateApplicationMessage(plaintext)
+		if err != nil {
+			t.Fatalf("message %d: create: %v", i, err)
+		}
+		decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+		if err != nil {
+			t.Fatalf("message %d: decrypt: %v", i, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("message %d: decrypted = %q, want %q", i, decrypted, plaintext)
+		}
+	}
+}
+
+func isAllZero(b []byte) bool {
+	for _, x := range b {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGroupState_EncryptedWithArgon2Params(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("encrypted-group-params"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("correct horse battery staple")
+	params := Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1, SaltLen: 16}
+
+	data, err := group.MarshalEncrypted(password, WithArgon2Params(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A blob encrypted with non-default params must still fail a wrong
+	// password and succeed with the right one; UnmarshalGroupStateEncrypted
+	// reads the params back out of the blob itself.
+	if _, err := UnmarshalGroupStateEncrypted(data, []byte("wrong password")); err == nil {
+		t.Fatal("expected error decrypting with wrong password")
+	}
+
+	restored, err := UnmarshalGroupStateEncrypted(data, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello with custom argon2 params")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestGroupState_EncryptedWrongPassword(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("encrypted-group-2"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.MarshalEncrypted([]byte("correct password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnmarshalGroupStateEncrypted(data, []byte("wrong password")); err == nil {
+		t.Fatal("expected error decrypting with wrong password")
+	}
+}
+
+func TestGroupState_EncryptedRejectsBadMagic(t *testing.T) {
+	if _, err := UnmarshalGroupStateEncrypted([]byte("not a group state"), []byte("pw")); err == nil {
+		t.Fatal("expected error for malformed blob")
+	}
+}
@@ -0,0 +1,214 @@
+package mls
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// publicGroupState is the subset of groupState that contains no secret
+// material: the group context, the ratchet tree (which already holds
+// only public leaf/parent data — private keys live in groupState.privTree,
+// not here), and the interim transcript hash.
+type publicGroupState struct {
+	groupContext          groupContext
+	tree                  ratchetTree
+	interimTranscriptHash []byte
+}
+
+func (ps *publicGroupState) marshal(b *cryptobyte.Builder) {
+	ps.groupContext.marshal(b)
+	ps.tree.marshal(b)
+	writeOpaqueVec(b, ps.interimTranscriptHash)
+}
+
+func (ps *publicGroupState) unmarshal(s *cryptobyte.String) error {
+	*ps = publicGroupState{}
+
+	if err := ps.groupContext.unmarshal(s); err != nil {
+		return fmt.Errorf("unmarshal group context: %w", err)
+	}
+	if err := ps.tree.unmarshal(s); err != nil {
+		return fmt.Errorf("unmarshal ratchet tree: %w", err)
+	}
+	if !readOpaqueVec(s, &ps.interimTranscriptHash) {
+		return fmt.Errorf("unmarshal interim transcript hash: unexpected EOF")
+	}
+
+	return nil
+}
+
+// MarshalPublic serializes the non-secret portions of the Group: the
+// group context, the ratchet tree, and the interim transcript hash. It
+// omits epochSecret, initSecret, pskSecret, privTree, and
+// signaturePriv, so the result is safe to hand to a delivery service for
+// auditing or to a UI layer that must not see key material.
+func (g *Group) MarshalPublic() ([]byte, error) {
+	ps := publicGroupState{
+		groupContext:          g.groupContext,
+		tree:                  g.tree,
+		interimTranscriptHash: g.interimTranscriptHash,
+	}
+	return marshal(&ps)
+}
+
+// PublicGroupView is a read-only view over the non-secret state produced
+// by Group.MarshalPublic.
+type PublicGroupView struct {
+	state publicGroupState
+}
+
+// UnmarshalPublicGroupState parses a blob produced by Group.MarshalPublic.
+func UnmarshalPublicGroupState(data []byte) (*PublicGroupView, error) {
+	var ps publicGroupState
+	if err := unmarshal(data, &ps); err != nil {
+		return nil, fmt.Errorf("unmarshal public group state: %w", err)
+	}
+	return &PublicGroupView{state: ps}, nil
+}
+
+// GroupID returns the identifier of the group.
+func (v *PublicGroupView) GroupID() GroupID {
+	return v.state.groupContext.groupID
+}
+
+// Epoch returns the epoch the view was taken at.
+func (v *PublicGroupView) Epoch() uint64 {
+	return v.state.groupContext.epoch
+}
+
+// TreeHash returns the ratchet tree hash recorded in the group context,
+// which callers can compare against a hash of Tree() to verify the view
+// hasn't been tampered with.
+func (v *PublicGroupView) TreeHash() []byte {
+	return v.state.groupContext.treeHash
+}
+
+// Tree returns the public ratchet tree, for membership enumeration by
+// the caller.
+func (v *PublicGroupView) Tree() ratchetTree {
+	return v.state.tree
+}
+
+// groupInfo is this package's own format for publishing enough of a
+// group's state for a new member to join without the full history,
+// together with a signature from the member who produced it. It is
+// loosely modeled on the RFC 9420 GroupInfo struct but is NOT
+// wire-compatible with it: the signature field below holds whatever
+// bytes the caller passed to MarshalGroupInfo, stored and returned as
+// given, not an RFC 9420 confirmation_tag, and extensions use
+// GroupStateExtension's TLV encoding rather than RFC 9420's
+// MLSExtension encoding. The output of MarshalGroupInfo is read back by
+// UnmarshalGroupInfo in this package, not by other MLS implementations
+// expecting RFC 9420 wire format.
+type groupInfo struct {
+	groupContext          groupContext
+	interimTranscriptHash []byte
+	extensions            []GroupStateExtension
+	signerIndex           leafIndex
+	signature             []byte
+}
+
+func (gi *groupInfo) marshal(b *cryptobyte.Builder) {
+	gi.groupContext.marshal(b)
+	writeOpaqueVec(b, gi.interimTranscriptHash)
+	writeVector(b, len(gi.extensions), func(b *cryptobyte.Builder, i int) {
+		gi.extensions[i].marshal(b)
+	})
+	b.AddUint32(uint32(gi.signerIndex))
+	writeOpaqueVec(b, gi.signature)
+}
+
+func (gi *groupInfo) unmarshal(s *cryptobyte.String) error {
+	*gi = groupInfo{}
+
+	if err := gi.groupContext.unmarshal(s); err != nil {
+		return fmt.Errorf("unmarshal group context: %w", err)
+	}
+	if !readOpaqueVec(s, &gi.interimTranscriptHash) {
+		return fmt.Errorf("unmarshal interim transcript hash: unexpected EOF")
+	}
+
+	err := readVector(s, func(s *cryptobyte.String) error {
+		var ext GroupStateExtension
+		if err := ext.unmarshal(s); err != nil {
+			return fmt.Errorf("unmarshal extension: %w", err)
+		}
+		gi.extensions = append(gi.extensions, ext)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unmarshal extensions: %w", err)
+	}
+
+	if !s.ReadUint32((*uint32)(&gi.signerIndex)) {
+		return fmt.Errorf("unmarshal signer index: unexpected EOF")
+	}
+	if !readOpaqueVec(s, &gi.signature) {
+		return fmt.Errorf("unmarshal signature: unexpected EOF")
+	}
+
+	return nil
+}
+
+// MarshalGroupInfo serializes g's current state as a groupInfo, for
+// publishing to a delivery service so a new member can join without
+// replaying the full group history. Unlike MarshalPublic, the result
+// carries extensions and is meant to be signed the same way a Commit's
+// own confirmation is signed: the caller supplies signature, the
+// signed confirmation over the group context. See groupInfo's doc
+// comment for how this differs from RFC 9420's GroupInfo.
+func (g *Group) MarshalGroupInfo(signature []byte, extensions []GroupStateExtension) ([]byte, error) {
+	gi := groupInfo{
+		groupContext:          g.groupContext,
+		interimTranscriptHash: g.interimTranscriptHash,
+		extensions:            extensions,
+		signerIndex:           g.myLeafIndex,
+		signature:             signature,
+	}
+	return marshal(&gi)
+}
+
+// GroupInfoView is a read-only view over a blob produced by
+// Group.MarshalGroupInfo. It does not verify Signature(); callers are
+// responsible for checking it against SignerIndex()'s public key using
+// whatever signature scheme the caller applied, since groupInfo's
+// signature is not a real RFC 9420 confirmation_tag (see groupInfo's
+// doc comment).
+type GroupInfoView struct {
+	info groupInfo
+}
+
+// UnmarshalGroupInfo parses a blob produced by Group.MarshalGroupInfo.
+func UnmarshalGroupInfo(data []byte) (*GroupInfoView, error) {
+	var gi groupInfo
+	if err := unmarshal(data, &gi); err != nil {
+		return nil, fmt.Errorf("unmarshal group info: %w", err)
+	}
+	return &GroupInfoView{info: gi}, nil
+}
+
+// GroupID returns the identifier of the group the info describes.
+func (v *GroupInfoView) GroupID() GroupID {
+	return v.info.groupContext.groupID
+}
+
+// Epoch returns the epoch the info was taken at.
+func (v *GroupInfoView) Epoch() uint64 {
+	return v.info.groupContext.epoch
+}
+
+// Extensions returns the extension entries the info was published with.
+func (v *GroupInfoView) Extensions() []GroupStateExtension {
+	return v.info.extensions
+}
+
+// SignerIndex returns the leaf index of the member who produced this info.
+func (v *GroupInfoView) SignerIndex() uint32 {
+	return uint32(v.info.signerIndex)
+}
+
+// Signature returns the signature MarshalGroupInfo was called with.
+func (v *GroupInfoView) Signature() []byte {
+	return v.info.signature
+}
@@ -0,0 +1,93 @@
+package mls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGroupState_MarshalPublicOmitsSecrets(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("public-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.MarshalPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := UnmarshalPublicGroupState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if view.GroupID() != GroupID("public-group") {
+		t.Fatalf("GroupID() = %q, want %q", view.GroupID(), GroupID("public-group"))
+	}
+
+	if view.Epoch() != group.groupContext.epoch {
+		t.Fatalf("Epoch() = %d, want %d", view.Epoch(), group.groupContext.epoch)
+	}
+}
+
+func TestGroupState_MarshalGroupInfoRoundtrip(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("group-info"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := []byte("fake-signature")
+	extensions := []GroupStateExtension{
+		NewGroupStateExtension(7, false, []byte("hello")),
+		NewGroupStateExtension(9, true, []byte("world")),
+	}
+
+	data, err := group.MarshalGroupInfo(signature, extensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := UnmarshalGroupInfo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if view.GroupID() != GroupID("group-info") {
+		t.Fatalf("GroupID() = %q, want %q", view.GroupID(), GroupID("group-info"))
+	}
+	if view.Epoch() != group.groupContext.epoch {
+		t.Fatalf("Epoch() = %d, want %d", view.Epoch(), group.groupContext.epoch)
+	}
+	if view.SignerIndex() != uint32(group.myLeafIndex) {
+		t.Fatalf("SignerIndex() = %d, want %d", view.SignerIndex(), uint32(group.myLeafIndex))
+	}
+	if !bytes.Equal(view.Signature(), signature) {
+		t.Fatalf("Signature() = %q, want %q", view.Signature(), signature)
+	}
+
+	got := view.Extensions()
+	if len(got) != len(extensions) {
+		t.Fatalf("len(Extensions()) = %d, want %d", len(got), len(extensions))
+	}
+	for i, want := range extensions {
+		if got[i].ExtType != want.ExtType || got[i].Critical != want.Critical || !bytes.Equal(got[i].Data, want.Data) {
+			t.Fatalf("Extensions()[%d] = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
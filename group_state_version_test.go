@@ -0,0 +1,238 @@
+package mls
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestGroupState_RejectsUnknownVersion(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("version-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the 2-byte version prefix to a value we don't understand.
+	var b cryptobyte.Builder
+	b.AddUint16(0xffff)
+	b.AddBytes(data[2:])
+	bogus := b.BytesOrPanic()
+
+	if _, err := UnmarshalGroupState(bogus); err == nil {
+		t.Fatal("expected error for unrecognized version")
+	}
+}
+
+func TestGroupState_MigratePreservesRoundtrip(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("migrate-version-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := Migrate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupState(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello after migrate")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestGroupState_MigrateKeyStoreBlob(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("migrate-keystore-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := NewMemoryKeyStore()
+
+	data, err := group.Marshal(WithKeyStore(ks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate with no KeyStore must fail the same way
+	// UnmarshalGroupStateWithKeyStore would on a handle-mode blob.
+	if _, err := Migrate(data); err == nil {
+		t.Fatal("expected error migrating a key-store blob with no KeyStore configured")
+	}
+
+	migrated, err := Migrate(data, WithMigrateKeyStore(ks))
+	if err != nil {
+		t.Fatalf("migrate key-store blob: %v", err)
+	}
+
+	restored, err := UnmarshalGroupStateWithKeyStore(migrated, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello after migrating a key-store blob")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+// buildV1Blob hand-encodes gs in the GroupStateVersion1 wire format: a
+// version prefix of 1 followed by the pre-KeyStore field layout, with no
+// handle-mode bit and no extension area. group.Marshal() always writes
+// groupStateCurrentVersion (V2), so this is the only way to exercise a
+// V1 blob.
+func buildV1Blob(gs *groupState) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(GroupStateVersion1)
+	gs.groupContext.marshal(&b)
+	gs.tree.marshal(&b)
+	writeOpaqueVec(&b, gs.interimTranscriptHash)
+	writeOpaqueVec(&b, gs.pskSecret)
+	writeOpaqueVec(&b, gs.epochSecret)
+	writeOpaqueVec(&b, gs.initSecret)
+	b.AddUint32(uint32(gs.myLeafIndex))
+
+	writeVector(&b, len(gs.privTree), func(b *cryptobyte.Builder, i int) {
+		key := gs.privTree[i]
+		writeOptional(b, key != nil)
+		if key == nil {
+			return
+		}
+		writeOpaqueVec(b, []byte(key))
+	})
+
+	writeOpaqueVec(&b, []byte(gs.signaturePriv))
+
+	writeVector(&b, len(gs.pendingProposals), func(b *cryptobyte.Builder, i int) {
+		gs.pendingProposals[i].marshal(b)
+	})
+
+	return b.BytesOrPanic()
+}
+
+func TestGroupState_UnmarshalAndMigrateTrueV1Blob(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("true-v1-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gs := groupState{
+		groupContext:          group.groupContext,
+		tree:                  group.tree,
+		interimTranscriptHash: group.interimTranscriptHash,
+		pskSecret:             group.pskSecret,
+		epochSecret:           group.epochSecret,
+		initSecret:            group.initSecret,
+		myLeafIndex:           group.myLeafIndex,
+		privTree:              group.privTree,
+		signaturePriv:         group.signaturePriv,
+		pendingProposals:      group.pendingProposals,
+	}
+	v1Blob := buildV1Blob(&gs)
+
+	restored, err := UnmarshalGroupState(v1Blob)
+	if err != nil {
+		t.Fatalf("unmarshal true v1 blob: %v", err)
+	}
+
+	plaintext := []byte("hello from a true v1 blob")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+
+	migrated, err := Migrate(v1Blob)
+	if err != nil {
+		t.Fatalf("migrate true v1 blob: %v", err)
+	}
+
+	restoredMigrated, err := UnmarshalGroupState(migrated)
+	if err != nil {
+		t.Fatalf("unmarshal migrated v1 blob: %v", err)
+	}
+
+	plaintext2 := []byte("hello after migrating a true v1 blob")
+	ciphertext2, err := restoredMigrated.CreateApplicationMessage(plaintext2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted2, err := group.UnmarshalAndProcessMessage(ciphertext2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted2, plaintext2) {
+		t.Fatalf("decrypted2 = %q, want %q", decrypted2, plaintext2)
+	}
+}
@@ -0,0 +1,306 @@
+package mls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// defaultWALCompactionRatio is how large the WAL is allowed to grow
+// relative to the last snapshot before Checkpoint is triggered
+// automatically.
+const defaultWALCompactionRatio = 2.0
+
+const (
+	walRecordProposal uint8 = 1
+	walRecordCommit   uint8 = 2
+)
+
+// GroupStore persists Group state as a periodic full snapshot plus an
+// append-only write-ahead log of the proposals and commits applied
+// since that snapshot. Steady-state persistence cost is proportional to
+// the size of each change rather than the size of the whole group,
+// which matters once the ratchet tree grows to thousands of members.
+type GroupStore struct {
+	dir             string
+	compactionRatio float64
+
+	mu sync.Mutex
+}
+
+// GroupStoreOption configures OpenGroupStore.
+type GroupStoreOption func(*GroupStore)
+
+// WithCompactionRatio overrides the default ratio of WAL size to
+// snapshot size (2x) at which AppendProposal/AppendCommit trigger an
+// automatic Checkpoint.
+func WithCompactionRatio(ratio float64) GroupStoreOption {
+	return func(s *GroupStore) { s.compactionRatio = ratio }
+}
+
+// OpenGroupStore opens (creating if necessary) a GroupStore rooted at dir.
+func OpenGroupStore(dir string, opts ...GroupStoreOption) (*GroupStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("open group store: %w", err)
+	}
+
+	s := &GroupStore{dir: dir, compactionRatio: defaultWALCompactionRatio}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+func (s *GroupStore) snapshotPath(id GroupID) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%x.snapshot", []byte(id)))
+}
+
+func (s *GroupStore) walPath(id GroupID) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%x.wal", []byte(id)))
+}
+
+// Checkpoint writes a full snapshot of g and truncates its WAL. The
+// snapshot is written to a temporary file, fsync'd, and renamed into
+// place, and the directory entry for the rename is itself fsync'd,
+// before the WAL is truncated — otherwise a crash between the rename
+// and the OS flushing the new snapshot could leave a stale or empty
+// snapshot paired with an already-truncated WAL, losing the group
+// entirely despite the WAL's own CRC/torn-write handling.
+func (s *GroupStore) Checkpoint(g *Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := g.Marshal()
+	if err != nil {
+		return fmt.Errorf("checkpoint group: marshal: %w", err)
+	}
+
+	path := s.snapshotPath(g.groupContext.groupID)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("checkpoint group: open snapshot: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("checkpoint group: write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("checkpoint group: fsync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("checkpoint group: close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("checkpoint group: install snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("checkpoint group: fsync snapshot directory: %w", err)
+	}
+
+	if err := os.Truncate(s.walPath(g.groupContext.groupID), 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("checkpoint group: truncate wal: %w", err)
+	}
+
+	return nil
+}
+
+// syncDir fsyncs a directory so a preceding rename within it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *GroupStore) appendRecord(id GroupID, recordType uint8, epoch uint64, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.walPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("append wal record: open: %w", err)
+	}
+	defer f.Close()
+
+	var header [13]byte
+	header[0] = recordType
+	binary.BigEndian.PutUint64(header[1:9], epoch)
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(payload)))
+
+	crc := crc32.ChecksumIEEE(header[:])
+	crc = crc32.Update(crc, crc32.IEEETable, payload)
+
+	if _, err := f.Write(header[:]); err != nil {
+		return fmt.Errorf("append wal record: write header: %w", err)
+	}
+	if _, err := f.Write(payload); err != nil {
+		return fmt.Errorf("append wal record: write payload: %w", err)
+	}
+	var crcBuf [4]byte
+	binary.BigEndian.PutUint32(crcBuf[:], crc)
+	if _, err := f.Write(crcBuf[:]); err != nil {
+		return fmt.Errorf("append wal record: write crc: %w", err)
+	}
+
+	return f.Sync()
+}
+
+// AppendProposal records the pending proposal referenced by ref, looked
+// up in g.pendingProposals, so a future LoadGroup can restore it into
+// the reloaded Group's pendingProposals before replaying any commit
+// that references it. Recording only ref would not be enough to
+// reconstruct the proposal after a crash, since nothing else in the WAL
+// carries its body.
+func (s *GroupStore) AppendProposal(g *Group, ref []byte) error {
+	pp, ok := findPendingProposal(g, ref)
+	if !ok {
+		return fmt.Errorf("append proposal: no pending proposal in group for ref")
+	}
+
+	var b cryptobyte.Builder
+	pp.marshal(&b)
+	payload, err := b.Bytes()
+	if err != nil {
+		return fmt.Errorf("append proposal: marshal: %w", err)
+	}
+
+	if err := s.appendRecord(g.groupContext.groupID, walRecordProposal, g.groupContext.epoch, payload); err != nil {
+		return fmt.Errorf("append proposal: %w", err)
+	}
+	return s.maybeCompact(g)
+}
+
+func findPendingProposal(g *Group, ref []byte) (pendingProposal, bool) {
+	for _, pp := range g.pendingProposals {
+		if bytes.Equal([]byte(pp.ref), ref) {
+			return pp, true
+		}
+	}
+	return pendingProposal{}, false
+}
+
+// AppendCommit records commitBytes, the wire-format Commit that advanced
+// g to its current epoch, so a future LoadGroup can replay it against
+// the last snapshot.
+func (s *GroupStore) AppendCommit(g *Group, commitBytes []byte) error {
+	if err := s.appendRecord(g.groupContext.groupID, walRecordCommit, g.groupContext.epoch, commitBytes); err != nil {
+		return fmt.Errorf("append commit: %w", err)
+	}
+	return s.maybeCompact(g)
+}
+
+func (s *GroupStore) maybeCompact(g *Group) error {
+	snapInfo, err := os.Stat(s.snapshotPath(g.groupContext.groupID))
+	if err != nil {
+		return nil // no snapshot yet; nothing to compare the wal against
+	}
+	walInfo, err := os.Stat(s.walPath(g.groupContext.groupID))
+	if err != nil {
+		return nil
+	}
+	if float64(walInfo.Size()) > float64(snapInfo.Size())*s.compactionRatio {
+		return s.Checkpoint(g)
+	}
+	return nil
+}
+
+// LoadGroup reconstructs a Group from its latest snapshot plus any WAL
+// records appended since. Records are replayed strictly in append
+// order: a proposal record is appended to pendingProposals as soon as
+// it's seen, and a commit record is processed immediately rather than
+// after every proposal, since a commit clears pendingProposals the way
+// any other commit does and a WAL can contain more than one
+// proposal-then-commit cycle (e.g. when Checkpoint hasn't run between
+// them) — replaying all proposals before any commit would let an
+// earlier commit discard a proposal that a later commit still needs.
+func (s *GroupStore) LoadGroup(id GroupID) (*Group, error) {
+	data, err := os.ReadFile(s.snapshotPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("load group: read snapshot: %w", err)
+	}
+
+	g, err := UnmarshalGroupState(data)
+	if err != nil {
+		return nil, fmt.Errorf("load group: unmarshal snapshot: %w", err)
+	}
+
+	walData, err := os.ReadFile(s.walPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return g, nil
+		}
+		return nil, fmt.Errorf("load group: read wal: %w", err)
+	}
+
+	records := parseWAL(walData)
+
+	for _, rec := range records {
+		switch rec.recordType {
+		case walRecordProposal:
+			var pp pendingProposal
+			payload := cryptobyte.String(rec.payload)
+			if err := pp.unmarshal(&payload); err != nil {
+				return nil, fmt.Errorf("load group: replay proposal at epoch %d: %w", rec.epoch, err)
+			}
+			g.pendingProposals = append(g.pendingProposals, pp)
+		case walRecordCommit:
+			if _, err := g.UnmarshalAndProcessMessage(rec.payload); err != nil {
+				return nil, fmt.Errorf("load group: replay commit at epoch %d: %w", rec.epoch, err)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+type walRecord struct {
+	recordType uint8
+	epoch      uint64
+	payload    []byte
+}
+
+// parseWAL decodes a sequence of WAL records, stopping at the first one
+// that fails its CRC32 check. Since the file is only ever appended to, a
+// torn write can only occur at the tail, so any prefix of valid records
+// is safe to replay.
+func parseWAL(data []byte) []walRecord {
+	var records []walRecord
+
+	for len(data) >= 13 {
+		recordType := data[0]
+		epoch := binary.BigEndian.Uint64(data[1:9])
+		payloadLen := binary.BigEndian.Uint32(data[9:13])
+
+		recordLen := 13 + int(payloadLen) + 4
+		if len(data) < recordLen {
+			break // torn write: incomplete payload or crc
+		}
+
+		header := data[:13]
+		payload := data[13 : 13+payloadLen]
+		wantCRC := binary.BigEndian.Uint32(data[13+payloadLen : recordLen])
+
+		crc := crc32.ChecksumIEEE(header)
+		crc = crc32.Update(crc, crc32.IEEETable, payload)
+		if crc != wantCRC {
+			break // torn write: corrupt tail record
+		}
+
+		records = append(records, walRecord{recordType: recordType, epoch: epoch, payload: payload})
+		data = data[recordLen:]
+	}
+
+	return records
+}
@@ -0,0 +1,208 @@
+package mls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGroupStore_WithCompactionRatio(t *testing.T) {
+	store, err := OpenGroupStore(t.TempDir(), WithCompactionRatio(5.0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.compactionRatio != 5.0 {
+		t.Fatalf("compactionRatio = %v, want 5.0", store.compactionRatio)
+	}
+}
+
+func TestGroupStore_CheckpointAndLoad(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("store-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenGroupStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Checkpoint(group); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := store.LoadGroup(GroupID("store-group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello from the store")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestGroupStore_ReplaysCommitsFromWAL(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	aliceCred := NewBasicCredential([]byte("alice"))
+	aliceKPP, err := GenerateKeyPairPackage(cs, aliceCred)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceGroup, err := CreateGroup(GroupID("store-wal-group"), aliceKPP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenGroupStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Checkpoint(aliceGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	bobCred := NewBasicCredential([]byte("bob"))
+	bobKPP, err := GenerateKeyPairPackage(cs, bobCred)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, commitBytes, err := aliceGroup.CreateWelcome([]KeyPackage{bobKPP.Public})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := aliceGroup.UnmarshalAndProcessMessage(commitBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.AppendCommit(aliceGroup, commitBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := store.LoadGroup(GroupID("store-wal-group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("after replay")
+	ciphertext, err := aliceGroup.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := restored.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+// TestGroupStore_ReplaysInterleavedProposalsAndCommits guards against
+// LoadGroup replaying a WAL in two passes (all proposals, then all
+// commits) instead of append order. A real commit clears the whole
+// pendingProposals queue, so replaying every proposal record before any
+// commit record would let an earlier commit discard a proposal queued
+// for a later one — this only shows up once a WAL holds more than one
+// commit without an intervening Checkpoint.
+func TestGroupStore_ReplaysInterleavedProposalsAndCommits(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	aliceCred := NewBasicCredential([]byte("alice"))
+	aliceKPP, err := GenerateKeyPairPackage(cs, aliceCred)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceGroup, err := CreateGroup(GroupID("store-interleave-group"), aliceKPP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenGroupStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Checkpoint(aliceGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	// A proposal queued before the first commit. Processing that commit
+	// clears the whole pending-proposal queue, so this one must not
+	// survive into the second round.
+	firstRef := []byte("first-pending-proposal")
+	pp1 := pendingProposal{proposal: new(proposal)}
+	*(*[]byte)(&pp1.ref) = firstRef
+	aliceGroup.pendingProposals = append(aliceGroup.pendingProposals, pp1)
+	if err := store.AppendProposal(aliceGroup, firstRef); err != nil {
+		t.Fatal(err)
+	}
+
+	update, err := aliceGroup.ProposeUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, commitBytes, err := aliceGroup.CreateCommit([]Proposal{update})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aliceGroup.UnmarshalAndProcessMessage(commitBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AppendCommit(aliceGroup, commitBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second proposal queued after the commit, with no second commit
+	// and no intervening Checkpoint: the WAL now holds proposal, commit,
+	// proposal, in that order.
+	secondRef := []byte("second-pending-proposal")
+	pp2 := pendingProposal{proposal: new(proposal)}
+	*(*[]byte)(&pp2.ref) = secondRef
+	aliceGroup.pendingProposals = append(aliceGroup.pendingProposals, pp2)
+	if err := store.AppendProposal(aliceGroup, secondRef); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := store.LoadGroup(GroupID("store-interleave-group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With correct append-order replay, the commit clears firstRef
+	// before secondRef is ever appended, so exactly secondRef survives.
+	// Replaying all proposals before any commit (the bug) would instead
+	// load both and then have the commit clear both.
+	if len(restored.pendingProposals) != 1 {
+		t.Fatalf("pendingProposals after replay = %d, want 1", len(restored.pendingProposals))
+	}
+	if !bytes.Equal([]byte(restored.pendingProposals[0].ref), secondRef) {
+		t.Fatalf("surviving pending proposal ref = %q, want %q", []byte(restored.pendingProposals[0].ref), secondRef)
+	}
+}
@@ -0,0 +1,202 @@
+package mls
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeyStore abstracts where MLS private key material lives. When a Group
+// is configured with one (via WithKeyStore), Marshal writes opaque
+// handles in place of raw key bytes for privTree and signaturePriv,
+// enabling deployments where private keys never leave an HSM or a
+// separate secret-management process.
+type KeyStore interface {
+	Store(handle []byte, key []byte) error
+	Load(handle []byte) ([]byte, error)
+	Delete(handle []byte) error
+}
+
+func privTreeKeyHandle(id GroupID, index int) []byte {
+	return []byte(fmt.Sprintf("mls/privtree/%x/%d", []byte(id), index))
+}
+
+func signaturePrivKeyHandle(id GroupID) []byte {
+	return []byte(fmt.Sprintf("mls/sigpriv/%x", []byte(id)))
+}
+
+// MemoryKeyStore is an in-memory KeyStore. It provides no persistence of
+// its own; it's mainly useful for tests, and for processes that keep
+// keys resident but still want the marshaled group to stay handle-based.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// NewMemoryKeyStore returns an empty MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string][]byte)}
+}
+
+func (m *MemoryKeyStore) Store(handle, key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := make([]byte, len(key))
+	copy(cp, key)
+	m.keys[string(handle)] = cp
+	return nil
+}
+
+func (m *MemoryKeyStore) Load(handle []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[string(handle)]
+	if !ok {
+		return nil, fmt.Errorf("memory key store: no key for handle")
+	}
+	cp := make([]byte, len(key))
+	copy(cp, key)
+	return cp, nil
+}
+
+func (m *MemoryKeyStore) Delete(handle []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, string(handle))
+	return nil
+}
+
+// FileKeyStore is a KeyStore backed by a directory of files, each
+// encrypted at rest with a single key supplied at construction. It's
+// meant for single-process deployments that want key material out of
+// the group blob but don't have an HSM available.
+type FileKeyStore struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// NewFileKeyStore opens (creating if necessary) a FileKeyStore rooted at
+// dir, encrypting stored keys with the given ChaCha20-Poly1305 key.
+func NewFileKeyStore(dir string, key []byte) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("new file key store: %w", err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("new file key store: %w", err)
+	}
+	return &FileKeyStore{dir: dir, aead: aead}, nil
+}
+
+func (f *FileKeyStore) path(handle []byte) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%x.key", handle))
+}
+
+func (f *FileKeyStore) Store(handle, key []byte) error {
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("file key store: generate nonce: %w", err)
+	}
+	sealed := f.aead.Seal(nonce, nonce, key, handle)
+	if err := os.WriteFile(f.path(handle), sealed, 0o600); err != nil {
+		return fmt.Errorf("file key store: %w", err)
+	}
+	return nil
+}
+
+func (f *FileKeyStore) Load(handle []byte) ([]byte, error) {
+	sealed, err := os.ReadFile(f.path(handle))
+	if err != nil {
+		return nil, fmt.Errorf("file key store: %w", err)
+	}
+	if len(sealed) < f.aead.NonceSize() {
+		return nil, fmt.Errorf("file key store: corrupt key file")
+	}
+	nonce, ciphertext := sealed[:f.aead.NonceSize()], sealed[f.aead.NonceSize():]
+	key, err := f.aead.Open(nil, nonce, ciphertext, handle)
+	if err != nil {
+		return nil, fmt.Errorf("file key store: decrypt: %w", err)
+	}
+	return key, nil
+}
+
+func (f *FileKeyStore) Delete(handle []byte) error {
+	if err := os.Remove(f.path(handle)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("file key store: %w", err)
+	}
+	return nil
+}
+
+// KeyStoreAlreadyStored is implemented by KeyStore adapters whose keys
+// are provisioned out of band — generated inside an HSM, say — rather
+// than through Store. groupState.marshal checks for this before calling
+// Store for a handle: if the KeyStore reports the handle as already
+// resident, marshal writes the handle without calling Store, since
+// Store would otherwise be the only way to get a key into that KeyStore
+// and some backends (PKCS11KeyStore included) can't support that.
+type KeyStoreAlreadyStored interface {
+	AlreadyStored(handle []byte) bool
+}
+
+// PKCS11Session is the slice of a PKCS#11 session that PKCS11KeyStore
+// needs. It's defined here rather than taken from a PKCS#11 binding
+// directly so this package doesn't have to take a cgo dependency; wire
+// up a real implementation against whichever PKCS#11 wrapper the
+// deployment already uses.
+type PKCS11Session interface {
+	FindKey(label []byte) (handle uint, err error)
+	DeleteKey(handle uint) error
+}
+
+// PKCS11KeyStore adapts an HSM session to the KeyStore interface.
+// Handles are PKCS#11 object labels. Private keys provisioned in an HSM
+// are non-extractable by design, so Store and Load are intentionally
+// unsupported: keys must be generated inside the HSM out of band, and
+// signing must go through the HSM's own signing operation rather than
+// through a loaded key. PKCS11KeyStore implements KeyStoreAlreadyStored
+// so groupState.marshal skips calling Store for handles that are
+// already provisioned, which is every handle this adapter can ever see.
+//
+// Because Load always fails, a blob written with
+// Marshal(WithKeyStore(pkcs11Store)) can never be read back through
+// UnmarshalGroupStateWithKeyStore: unmarshal resolves every handle
+// eagerly, and there's no way for this adapter to hand back a usable
+// key. PKCS11KeyStore is therefore only safe to pair with Marshal for
+// applications that don't need to reload state through this package;
+// restoring a Group whose signing key lives in an HSM requires signing
+// through the HSM directly rather than through this KeyStore.
+type PKCS11KeyStore struct {
+	Session PKCS11Session
+}
+
+// AlreadyStored reports whether handle already names a key inside the
+// HSM, so marshal can skip the unsupported Store call for it.
+func (p *PKCS11KeyStore) AlreadyStored(handle []byte) bool {
+	_, err := p.Session.FindKey(handle)
+	return err == nil
+}
+
+func (p *PKCS11KeyStore) Store(handle, key []byte) error {
+	return fmt.Errorf("pkcs11 key store: keys must be provisioned in the HSM directly, not via Store")
+}
+
+// Load always fails: PKCS#11 keys are non-extractable by design, so
+// there is no raw key material to hand back. This means a blob
+// marshaled with this KeyStore cannot be restored through
+// UnmarshalGroupStateWithKeyStore; see the PKCS11KeyStore doc comment.
+func (p *PKCS11KeyStore) Load(handle []byte) ([]byte, error) {
+	return nil, fmt.Errorf("pkcs11 key store: keys are non-extractable; sign through the HSM session instead of Load")
+}
+
+func (p *PKCS11KeyStore) Delete(handle []byte) error {
+	h, err := p.Session.FindKey(handle)
+	if err != nil {
+		return fmt.Errorf("pkcs11 key store: %w", err)
+	}
+	return p.Session.DeleteKey(h)
+}
@@ -0,0 +1,134 @@
+package mls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGroupState_MarshalWithKeyStore(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("keystore-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := NewMemoryKeyStore()
+
+	data, err := group.Marshal(WithKeyStore(ks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnmarshalGroupState(data); err == nil {
+		t.Fatal("expected error unmarshaling handle-based blob without a KeyStore")
+	}
+
+	restored, err := UnmarshalGroupStateWithKeyStore(data, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello via key store")
+	ciphertext, err := restored.CreateApplicationMessage(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := group.UnmarshalAndProcessMessage(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestGroupState_MigrateToKeyStore(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("migrate-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inBand, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := NewMemoryKeyStore()
+	migrated, err := MigrateToKeyStore(inBand, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := UnmarshalGroupStateWithKeyStore(migrated, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.myLeafIndex != group.myLeafIndex {
+		t.Fatalf("myLeafIndex = %d, want %d", restored.myLeafIndex, group.myLeafIndex)
+	}
+}
+
+// fakePKCS11Session is a PKCS11Session that already knows about every
+// handle it's asked to find, simulating an HSM where keys have already
+// been provisioned out of band under the handles groupState derives.
+type fakePKCS11Session struct {
+	deleted map[string]bool
+}
+
+func (f *fakePKCS11Session) FindKey(label []byte) (uint, error) {
+	return 1, nil
+}
+
+func (f *fakePKCS11Session) DeleteKey(handle uint) error {
+	return nil
+}
+
+func TestGroupState_MarshalWithPKCS11KeyStoreSkipsStore(t *testing.T) {
+	cs := CipherSuiteMLS_128_DHKEMX25519_CHACHA20POLY1305_SHA256_Ed25519
+
+	credential := NewBasicCredential([]byte("alice"))
+	kpp, err := GenerateKeyPairPackage(cs, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := CreateGroup(GroupID("pkcs11-group"), kpp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := &PKCS11KeyStore{Session: &fakePKCS11Session{}}
+
+	// Marshal must succeed even though PKCS11KeyStore.Store always
+	// errors, because every handle is reported AlreadyStored.
+	data, err := group.Marshal(WithKeyStore(ks))
+	if err != nil {
+		t.Fatalf("marshal with pkcs11 key store: %v", err)
+	}
+
+	// Unmarshal can never succeed against a PKCS11KeyStore: Load is
+	// permanently unsupported, so a blob marshaled this way isn't
+	// restorable through this API at all. See the PKCS11KeyStore and
+	// UnmarshalGroupStateWithKeyStore doc comments.
+	if _, err := UnmarshalGroupStateWithKeyStore(data, ks); err == nil {
+		t.Fatal("expected error unmarshaling a pkcs11-backed blob, since Load is unsupported")
+	}
+}